@@ -0,0 +1,40 @@
+package simulation
+
+import "testing"
+
+// buildBenchCircuit returns a circuitDef modeling a large circuit whose
+// activity stays confined to a small region each tick: a big pool of
+// wires that never change, alongside a handful of long conducting chains
+// driven by a power source, each rippling one wire further every two
+// Steps.
+func buildBenchCircuit(quiescentWires, chains, chainLength int) circuitDef {
+	def := circuitDef{wires: make([]wireDef, quiescentWires+chains*(chainLength+1))}
+	for i := 0; i < quiescentWires; i++ {
+		def.wires[i] = wireDef{charge: 0}
+	}
+
+	index := quiescentWires
+	for c := 0; c < chains; c++ {
+		def.wires[index] = wireDef{isPowerSource: true, charge: 0}
+		index++
+		for i := 0; i < chainLength; i++ {
+			def.wires[index] = wireDef{charge: 0}
+			def.transistors = append(def.transistors, transistorDef{base: -1, inputA: index - 1, inputB: index})
+			index++
+		}
+	}
+	return def
+}
+
+// BenchmarkStepLargeCircuitSmallActiveRegion measures Step's cost on a
+// circuit with tens of thousands of wires where, each tick, only the
+// wires reachable from whatever changed on the previous tick are ever
+// re-examined -- the property recalcListIn/recalcListOut exists for.
+func BenchmarkStepLargeCircuitSmallActiveRegion(b *testing.B) {
+	sim := newSimulation(buildBenchCircuit(20000, 20, 40))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sim = sim.Step()
+	}
+}
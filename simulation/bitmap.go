@@ -0,0 +1,28 @@
+package simulation
+
+const (
+	bitmapShift = 6
+	bitmapMask  = 63
+)
+
+// bitmap is a set of flags, one per node index, packed into 64-bit words.
+// It backs the recalc-list membership and group-visited tracking
+// Simulation.Step uses to skip everything but the wires a tick actually
+// touches.
+type bitmap []uint64
+
+func newBitmap(n int) bitmap {
+	return make(bitmap, (n+bitmapMask)>>bitmapShift)
+}
+
+func (b bitmap) set(i int) {
+	b[i>>bitmapShift] |= 1 << uint(i&bitmapMask)
+}
+
+func (b bitmap) clear(i int) {
+	b[i>>bitmapShift] &^= 1 << uint(i&bitmapMask)
+}
+
+func (b bitmap) get(i int) bool {
+	return b[i>>bitmapShift]&(1<<uint(i&bitmapMask)) != 0
+}
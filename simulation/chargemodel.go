@@ -0,0 +1,183 @@
+package simulation
+
+// Attributes are the static per-wire parameters a ChargeModel consults
+// when deciding how a wire behaves within its group: how strongly it
+// pulls the group up or down when nothing else is driving it, how hard a
+// non-power wire can drive on its own, and how many charge units it loses
+// per Step when undriven. The zero value is inert -- it asks nothing of
+// any model.
+type Attributes struct {
+	Pullup        uint8
+	Pulldown      uint8
+	DriveStrength uint8
+	DecayRate     uint8
+}
+
+// GroupState summarizes either a single wire's immediate neighbors or an
+// entire flood-filled group, depending on which a ChargeModel asks
+// resolveLocalState or resolveGroupState for: whether an active MaxCharge
+// driver is present, the highest raw charge present, and the strongest
+// pull/drive attributes among the members folded in.
+type GroupState struct {
+	MaxCharge     uint8
+	Driven        bool
+	Pullup        uint8
+	Pulldown      uint8
+	DriveStrength uint8
+}
+
+// ChargeModel decides how a wire's charge evolves each Step. MaxCharge is
+// the top of the range this model uses. NextCharge computes a wire's next
+// charge from its previous charge, local (the wire's own state folded
+// with its directly connected neighbors -- one transistor hop, no
+// further) and region (the whole flood-filled group of wires currently
+// reachable from it across any number of conducting transistors).
+// DefaultModel uses local so charge ripples hop by hop; CMOSLikeModel
+// uses region so a continuous node settles together.
+type ChargeModel interface {
+	MaxCharge() uint8
+	NextCharge(wire *Wire, charge uint8, local, region GroupState) uint8
+}
+
+// foldWireState folds a single wire's current charge and attributes into
+// state: an active driver at maxCharge wins outright, otherwise pull and
+// drive attributes are taken at their strongest.
+func foldWireState(state *GroupState, wire *Wire, charge uint8, maxCharge uint8) {
+	if charge == maxCharge {
+		state.Driven = true
+	}
+	if charge > state.MaxCharge {
+		state.MaxCharge = charge
+	}
+	if wire.attributes.Pullup > state.Pullup {
+		state.Pullup = wire.attributes.Pullup
+	}
+	if wire.attributes.Pulldown > state.Pulldown {
+		state.Pulldown = wire.attributes.Pulldown
+	}
+	if !wire.isPowerSource && wire.attributes.DriveStrength > state.DriveStrength {
+		state.DriveStrength = wire.attributes.DriveStrength
+	}
+}
+
+// resolveGroupState folds every member of a flood-filled group down to
+// the GroupState CMOSLikeModel needs to treat it as one electrically
+// continuous node.
+func resolveGroupState(group []int, wires []*Wire, charges *chargeSet, maxCharge uint8) GroupState {
+	var state GroupState
+	for _, index := range group {
+		foldWireState(&state, wires[index], charges.get(index), maxCharge)
+	}
+	return state
+}
+
+// resolveLocalState folds a single wire together with its directly
+// connected neighbors -- the wires reachable across exactly one currently
+// conducting transistor -- without flooding any further. This is the
+// granularity DefaultModel needs: a wire only ever sees what its
+// immediate neighbors were doing last Step, so charge visibly ripples one
+// hop per Step down a chain of series transistors instead of the whole
+// chain jumping to the same value at once.
+func resolveLocalState(wire *Wire, charges *chargeSet, maxCharge uint8) GroupState {
+	var state GroupState
+	foldWireState(&state, wire, charges.get(wire.index), maxCharge)
+	for _, transistor := range wire.transistors {
+		if nil != transistor.base && charges.get(transistor.base.index) > 0 {
+			continue
+		}
+		other := transistor.inputA
+		if other == wire {
+			other = transistor.inputB
+		}
+		foldWireState(&state, other, charges.get(other.index), maxCharge)
+	}
+	return state
+}
+
+type defaultModel struct {
+	maxCharge uint8
+}
+
+// DefaultModel returns the charge model matching this package's original,
+// hard-coded behavior: power-source wires ramp to MaxCharge one step per
+// Step, everything else ramps towards (or decays towards) the highest
+// charge among its immediate neighbors -- the wires reachable across one
+// currently conducting transistor -- one step per Step. Because that
+// target comes from local, not the whole flood-filled group, charge
+// visibly ripples down a chain of series-conducting transistors over
+// several Steps rather than every wire on the chain moving in lockstep.
+// It ignores Attributes entirely, so wires built without setting any keep
+// behaving exactly as they did before ChargeModel existed.
+func DefaultModel() ChargeModel {
+	return defaultModel{maxCharge: MaxCharge}
+}
+
+func (m defaultModel) MaxCharge() uint8 {
+	return m.maxCharge
+}
+
+func (m defaultModel) NextCharge(wire *Wire, charge uint8, local, region GroupState) uint8 {
+	if wire.isPowerSource {
+		if charge < m.maxCharge {
+			return charge + 1
+		}
+		return charge
+	}
+	target := local.MaxCharge
+	if local.Driven {
+		target = m.maxCharge
+	}
+	if target > charge+1 {
+		return charge + 1
+	}
+	if target <= charge && charge > 0 {
+		return charge - 1
+	}
+	return charge
+}
+
+type cmosLikeModel struct {
+	maxCharge uint8
+}
+
+// CMOSLikeModel returns a charge model where a driven-high group -- one
+// already holding a wire at MaxCharge -- propagates to every member
+// instantly rather than ramping one pixel of charge per Step, matching how
+// real CMOS logic settles far faster than it decays. A power source below
+// MaxCharge still ramps one step per Step; until it reaches MaxCharge the
+// rest of its group takes the group's highest charge each Step. Weak pullups/pulldowns and limited-strength drivers (via
+// Attributes) still only bias a group when nothing actively drives it.
+func CMOSLikeModel() ChargeModel {
+	return cmosLikeModel{maxCharge: MaxCharge}
+}
+
+func (m cmosLikeModel) MaxCharge() uint8 {
+	return m.maxCharge
+}
+
+func (m cmosLikeModel) NextCharge(wire *Wire, charge uint8, local, region GroupState) uint8 {
+	if wire.isPowerSource || region.Driven {
+		return m.maxCharge
+	}
+	target := region.MaxCharge
+	if region.DriveStrength > target {
+		target = region.DriveStrength
+	}
+	if target > 0 {
+		return target
+	}
+	if region.Pullup > 0 {
+		return region.Pullup
+	}
+	if charge == 0 || region.Pulldown > 0 {
+		return 0
+	}
+	decay := wire.attributes.DecayRate
+	if decay == 0 {
+		decay = 1
+	}
+	if decay >= charge {
+		return 0
+	}
+	return charge - decay
+}
@@ -0,0 +1,41 @@
+package simulation
+
+// chargeSet stores every wire's charge as a one-hot bitmap per possible
+// charge level (0..MaxCharge): changing a wire's charge touches exactly
+// two bitmaps regardless of circuit size, and reading it back is a
+// handful of bit tests instead of slice indexing into value structs.
+type chargeSet struct {
+	levels [MaxCharge + 1]bitmap
+}
+
+func newChargeSet(n int) *chargeSet {
+	cs := &chargeSet{}
+	for level := range cs.levels {
+		cs.levels[level] = newBitmap(n)
+	}
+	return cs
+}
+
+func (cs *chargeSet) get(index int) uint8 {
+	for level := len(cs.levels) - 1; level >= 0; level-- {
+		if cs.levels[level].get(index) {
+			return uint8(level)
+		}
+	}
+	return 0
+}
+
+func (cs *chargeSet) set(index int, charge uint8) {
+	cs.levels[cs.get(index)].clear(index)
+	cs.levels[charge].set(index)
+}
+
+func (cs *chargeSet) clone() *chargeSet {
+	clone := &chargeSet{}
+	for level, b := range cs.levels {
+		dup := make(bitmap, len(b))
+		copy(dup, b)
+		clone.levels[level] = dup
+	}
+	return clone
+}
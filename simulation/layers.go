@@ -0,0 +1,44 @@
+package simulation
+
+import "image"
+
+// NewFromLayers builds a circuit from a stack of wiring planes, one per
+// image, instead of the single plane New reads. Each layer is flood-merged
+// and scanned for transistors independently, so a gate on one layer can
+// never accidentally trigger a transistor on another. vias lists the pixel
+// coordinates at which adjacent layers are electrically joined: for each
+// via point, the wire occupying that (x, y) on layer n is merged with the
+// wire occupying it on layer n+1, all the way through the stack. This is
+// the only way wires on different layers connect -- it lets a circuit
+// route around itself non-planarly, which a single image can never do.
+func NewFromLayers(layers []*image.Paletted, vias []image.Point) *Simulation {
+	matrices := make([]*bucketMatrix, len(layers))
+	groups := make(map[*group]struct{}, 0)
+	for layer, img := range layers {
+		matrix, layerGroups := buildBucketGroups(img)
+		matrices[layer] = matrix
+		for g := range layerGroups {
+			groups[g] = struct{}{}
+		}
+	}
+
+	for _, via := range vias {
+		for layer := 0; layer < len(matrices)-1; layer++ {
+			topBucket := matrices[layer].get(via.X, via.Y)
+			bottomBucket := matrices[layer+1].get(via.X, via.Y)
+			if nil == topBucket || nil == bottomBucket || topBucket.group == bottomBucket.group {
+				continue
+			}
+			delete(groups, topBucket.group)
+			topBucket.group.moveContentTo(bottomBucket.group)
+		}
+	}
+
+	def := circuitDef{wires: make([]wireDef, len(groups))}
+	assignWireIndices(groups, &def)
+	for layer, img := range layers {
+		appendTransistorDefs(&def, matrices[layer], img.Bounds().Size())
+	}
+
+	return newSimulation(def)
+}
@@ -0,0 +1,249 @@
+package simulation
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+)
+
+const netlistHeader = "wired-logic-netlist v2"
+
+// circuitDef is the structural description of a circuit: which wires
+// exist, their initial charge, power-source flag and pixel geometry, and
+// how transistors wire them together. Both New (reading an image) and
+// LoadNetlist (reading text) build one of these and hand it to
+// newSimulation, so neither path has its own copy of the simulation
+// construction code.
+type circuitDef struct {
+	wires       []wireDef
+	transistors []transistorDef
+}
+
+type wireDef struct {
+	isPowerSource bool
+	charge        uint8
+	pixels        []image.Point
+	attributes    Attributes
+}
+
+// transistorDef references wires by index into circuitDef.wires. base is
+// -1 for a transistor with no gate (always conducting).
+type transistorDef struct {
+	position image.Point
+	base     int
+	inputA   int
+	inputB   int
+}
+
+func newSimulation(def circuitDef) *Simulation {
+	wires := make([]*Wire, len(def.wires))
+	wireStates := make([]WireState, len(def.wires))
+	for index, wd := range def.wires {
+		wire := newWire()
+		wire.index = index
+		wire.isPowerSource = wd.isPowerSource
+		wire.attributes = wd.attributes
+		wire.pixels = wd.pixels
+		for _, pixel := range wd.pixels {
+			wire.bounds = wire.bounds.Union(image.Rectangle{pixel, pixel.Add(image.Point{1, 1})})
+		}
+		wires[index] = wire
+		wireStates[index] = WireState{wd.charge, wire}
+	}
+
+	transistors := make([]*Transistor, len(def.transistors))
+	for index, td := range def.transistors {
+		var base *Wire
+		if td.base >= 0 {
+			base = wires[td.base]
+		}
+		transistors[index] = newTransistor(td.position, base, wires[td.inputA], wires[td.inputB])
+	}
+
+	recalcListIn := make([]int, len(wires))
+	for index := range recalcListIn {
+		recalcListIn[index] = index
+	}
+
+	charges := newChargeSet(len(wires))
+	var mix [2]uint64
+	for index, state := range wireStates {
+		charges.set(index, state.charge)
+		charged := mixCharge(index, state.charge)
+		mix[0] ^= charged[0]
+		mix[1] ^= charged[1]
+	}
+
+	return &Simulation{&Circuit{wires: wires, transistors: transistors}, charges, DefaultModel(), recalcListIn, mix}
+}
+
+// LoadNetlist reads a circuit from its textual netlist representation, the
+// format written by Simulation.SaveNetlist. Unlike New, which derives a
+// circuit from pixel geometry, LoadNetlist lets circuits be hand-authored
+// or generated far larger than any drawable image, and diffed in git.
+func LoadNetlist(r io.Reader) (*Simulation, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("netlist: empty input")
+	}
+	if scanner.Text() != netlistHeader {
+		return nil, fmt.Errorf("netlist: unrecognized header %q", scanner.Text())
+	}
+
+	wireCount, err := scanSectionHeader(scanner, "wires")
+	if err != nil {
+		return nil, err
+	}
+
+	def := circuitDef{wires: make([]wireDef, wireCount)}
+	for n := 0; n < wireCount; n++ {
+		if !scanner.Scan() {
+			return nil, fmt.Errorf("netlist: expected %d wires, found %d", wireCount, n)
+		}
+		var index, isPowerSource int
+		var charge, pullup, pulldown, driveStrength, decayRate uint8
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d %d %d %d %d",
+			&index, &isPowerSource, &charge, &pullup, &pulldown, &driveStrength, &decayRate); err != nil {
+			return nil, fmt.Errorf("netlist: invalid wire line %q: %w", scanner.Text(), err)
+		}
+		if index < 0 || index >= wireCount {
+			return nil, fmt.Errorf("netlist: wire index %d out of range", index)
+		}
+		def.wires[index] = wireDef{
+			isPowerSource: isPowerSource != 0,
+			charge:        charge,
+			attributes: Attributes{
+				Pullup:        pullup,
+				Pulldown:      pulldown,
+				DriveStrength: driveStrength,
+				DecayRate:     decayRate,
+			},
+		}
+	}
+
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("netlist: missing transistors section")
+	}
+	line := scanner.Text()
+
+	var pixelCount int
+	if n, _ := fmt.Sscanf(line, "pixels %d", &pixelCount); n == 1 {
+		for n := 0; n < pixelCount; n++ {
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("netlist: expected %d pixels, found %d", pixelCount, n)
+			}
+			var wireIndex, x, y int
+			if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d", &wireIndex, &x, &y); err != nil {
+				return nil, fmt.Errorf("netlist: invalid pixel line %q: %w", scanner.Text(), err)
+			}
+			if wireIndex < 0 || wireIndex >= wireCount {
+				return nil, fmt.Errorf("netlist: pixel references out-of-range wire %d", wireIndex)
+			}
+			def.wires[wireIndex].pixels = append(def.wires[wireIndex].pixels, image.Point{X: x, Y: y})
+		}
+		if !scanner.Scan() {
+			return nil, fmt.Errorf("netlist: missing transistors section")
+		}
+		line = scanner.Text()
+	}
+
+	var transistorCount int
+	if _, err := fmt.Sscanf(line, "transistors %d", &transistorCount); err != nil {
+		return nil, fmt.Errorf("netlist: invalid transistors header %q: %w", line, err)
+	}
+	def.transistors = make([]transistorDef, transistorCount)
+	for n := 0; n < transistorCount; n++ {
+		if !scanner.Scan() {
+			return nil, fmt.Errorf("netlist: expected %d transistors, found %d", transistorCount, n)
+		}
+		var x, y, base, inputA, inputB int
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d %d %d", &x, &y, &base, &inputA, &inputB); err != nil {
+			return nil, fmt.Errorf("netlist: invalid transistor line %q: %w", scanner.Text(), err)
+		}
+		if base < -1 || base >= wireCount || inputA < 0 || inputA >= wireCount || inputB < 0 || inputB >= wireCount {
+			return nil, fmt.Errorf("netlist: transistor references out-of-range wire in line %q", scanner.Text())
+		}
+		def.transistors[n] = transistorDef{image.Point{X: x, Y: y}, base, inputA, inputB}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("netlist: %w", err)
+	}
+
+	return newSimulation(def), nil
+}
+
+func scanSectionHeader(scanner *bufio.Scanner, name string) (int, error) {
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("netlist: missing %s section", name)
+	}
+	var count int
+	if _, err := fmt.Sscanf(scanner.Text(), name+" %d", &count); err != nil {
+		return 0, fmt.Errorf("netlist: invalid %s header %q: %w", name, scanner.Text(), err)
+	}
+	return count, nil
+}
+
+// SaveNetlist writes the circuit, its current charges and every wire's
+// Attributes in the textual format LoadNetlist reads back, so a round
+// trip through disk never silently drops a pullup, pulldown, drive
+// strength or decay rate a ChargeModel depends on.
+func (s *Simulation) SaveNetlist(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	if _, err := fmt.Fprintln(bw, netlistHeader); err != nil {
+		return err
+	}
+
+	wires := s.circuit.wires
+	if _, err := fmt.Fprintf(bw, "wires %d\n", len(wires)); err != nil {
+		return err
+	}
+	for _, wire := range wires {
+		isPowerSource := 0
+		if wire.isPowerSource {
+			isPowerSource = 1
+		}
+		if _, err := fmt.Fprintf(bw, "%d %d %d %d %d %d %d\n", wire.index, isPowerSource, s.charges.get(wire.index),
+			wire.attributes.Pullup, wire.attributes.Pulldown, wire.attributes.DriveStrength, wire.attributes.DecayRate); err != nil {
+			return err
+		}
+	}
+
+	pixelCount := 0
+	for _, wire := range wires {
+		pixelCount += len(wire.pixels)
+	}
+	if pixelCount > 0 {
+		if _, err := fmt.Fprintf(bw, "pixels %d\n", pixelCount); err != nil {
+			return err
+		}
+		for _, wire := range wires {
+			for _, pixel := range wire.pixels {
+				if _, err := fmt.Fprintf(bw, "%d %d %d\n", wire.index, pixel.X, pixel.Y); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	transistors := s.circuit.transistors
+	if _, err := fmt.Fprintf(bw, "transistors %d\n", len(transistors)); err != nil {
+		return err
+	}
+	for _, transistor := range transistors {
+		base := -1
+		if transistor.base != nil {
+			base = transistor.base.index
+		}
+		if _, err := fmt.Fprintf(bw, "%d %d %d %d %d\n",
+			transistor.position.X, transistor.position.Y, base, transistor.inputA.index, transistor.inputB.index); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
@@ -1,7 +1,6 @@
 package simulation
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"image"
@@ -34,7 +33,22 @@ func (w WireState) Wire() *Wire {
 
 type Simulation struct {
 	circuit *Circuit
-	states  []WireState
+	charges *chargeSet
+	model   ChargeModel
+
+	// recalcListIn holds the indices of wires that changed charge (or sit
+	// behind a transistor whose conduction state changed) on the previous
+	// Step. Only the connected groups reachable from these wires are
+	// re-evaluated, so Step costs are linear in the number of changed
+	// nodes rather than in the size of the whole circuit.
+	recalcListIn []int
+
+	// mix is a rolling fingerprint of every wire's charge, XORed in and out
+	// incrementally as charges change in Step so Hash never has to walk
+	// the whole circuit. It carries two independently-mixed 64-bit lanes
+	// rather than one, so FindLooping's birthday-bound collision risk
+	// scales with a 128-bit space instead of a 64-bit one.
+	mix [2]uint64
 }
 
 func (s *Simulation) Circuit() *Circuit {
@@ -42,10 +56,39 @@ func (s *Simulation) Circuit() *Circuit {
 }
 
 func (s *Simulation) State(wire *Wire) WireState {
-	return s.states[wire.index]
+	return WireState{s.charges.get(wire.index), wire}
+}
+
+// Model returns the ChargeModel governing how this simulation's wires
+// evolve each Step.
+func (s *Simulation) Model() ChargeModel {
+	return s.model
+}
+
+// WithModel returns a copy of the simulation that steps using model from
+// now on, leaving the current circuit and charges untouched.
+func (s *Simulation) WithModel(model ChargeModel) *Simulation {
+	clone := *s
+	clone.model = model
+	return &clone
 }
 
 func New(img *image.Paletted) *Simulation {
+	matrix, groups := buildBucketGroups(img)
+
+	def := circuitDef{wires: make([]wireDef, len(groups))}
+	assignWireIndices(groups, &def)
+	appendTransistorDefs(&def, matrix, img.Bounds().Size())
+
+	return newSimulation(def)
+}
+
+// buildBucketGroups runs the flood-merge pass over a single image plane,
+// turning same-colour runs of pixels into buckets and unioning touching
+// buckets into groups. It returns the finished bucket matrix (still
+// needed afterwards to detect transistors) and the set of groups it
+// produced.
+func buildBucketGroups(img *image.Paletted) (*bucketMatrix, map[*group]struct{}) {
 	size := img.Bounds().Size()
 	groups := make(map[*group]struct{}, 0)
 	matrix := newBucketMatrix(size.X, size.Y)
@@ -112,7 +155,25 @@ func New(img *image.Paletted) *Simulation {
 		}
 	}
 
-	transistors := make([]*Transistor, 0)
+	return matrix, groups
+}
+
+// assignWireIndices gives each group's wire the next free index into
+// def.wires and records its wireDef. Callers that merge groups across
+// several bucket matrices (NewFromLayers) must do so before calling this.
+func assignWireIndices(groups map[*group]struct{}, def *circuitDef) {
+	i := 0
+	for k := range groups {
+		k.wire.index = i
+		def.wires[i] = wireDef{isPowerSource: k.wire.isPowerSource, charge: k.wireState.charge, pixels: k.wire.pixels}
+		i++
+	}
+}
+
+// appendTransistorDefs scans a finished bucket matrix for the 3-bucket
+// gap patterns that mark a transistor and appends a transistorDef for
+// each, referencing wires by the index assignWireIndices gave them.
+func appendTransistorDefs(def *circuitDef, matrix *bucketMatrix, size image.Point) {
 	for y := 0; y < size.Y; y++ {
 		for x := 0; x < size.X; x++ {
 			if nil != matrix.get(x, y) {
@@ -130,99 +191,113 @@ func New(img *image.Paletted) *Simulation {
 			switch {
 			case nil == bottomLeftBucket && nil == bottomRightBucket &&
 				nil == topBucket && nil != rightBucket && nil != bottomBucket && nil != leftBucket:
-				transistors = append(transistors,
-					newTransistor(image.Point{x, y}, bottomBucket.group.wire, rightBucket.group.wire, leftBucket.group.wire))
+				def.transistors = append(def.transistors, transistorDef{image.Point{x, y},
+					bottomBucket.group.wire.index, rightBucket.group.wire.index, leftBucket.group.wire.index})
 			case nil == bottomLeftBucket && nil == topLeftBucket &&
 				nil != topBucket && nil == rightBucket && nil != bottomBucket && nil != leftBucket:
-				transistors = append(transistors,
-					newTransistor(image.Point{x, y}, leftBucket.group.wire, topBucket.group.wire, bottomBucket.group.wire))
+				def.transistors = append(def.transistors, transistorDef{image.Point{x, y},
+					leftBucket.group.wire.index, topBucket.group.wire.index, bottomBucket.group.wire.index})
 			case nil == topLeftBucket && nil == topRightBucket &&
 				nil != topBucket && nil != rightBucket && nil == bottomBucket && nil != leftBucket:
-				transistors = append(transistors,
-					newTransistor(image.Point{x, y}, topBucket.group.wire, rightBucket.group.wire, leftBucket.group.wire))
+				def.transistors = append(def.transistors, transistorDef{image.Point{x, y},
+					topBucket.group.wire.index, rightBucket.group.wire.index, leftBucket.group.wire.index})
 			case nil == bottomRightBucket && nil == topRightBucket &&
 				nil != topBucket && nil != rightBucket && nil != bottomBucket && nil == leftBucket:
-				transistors = append(transistors,
-					newTransistor(image.Point{x, y}, rightBucket.group.wire, topBucket.group.wire, bottomBucket.group.wire))
+				def.transistors = append(def.transistors, transistorDef{image.Point{x, y},
+					rightBucket.group.wire.index, topBucket.group.wire.index, bottomBucket.group.wire.index})
 			}
 		}
 	}
-
-	wires := make([]*Wire, len(groups))
-	wireStates := make([]WireState, len(groups))
-	i := 0
-	for k := range groups {
-		k.wire.index = i
-		wires[i] = k.wire
-		wireStates[i] = k.wireState
-		i++
-	}
-
-	return &Simulation{&Circuit{wires: wires, transistors: transistors}, wireStates}
 }
 
-func (s *Simulation) Step() *Simulation {
-	newWireState := make([]WireState, len(s.states))
-	for i, state := range s.states {
-		charge := state.charge
-		if state.wire.isPowerSource {
-			if state.charge < MaxCharge {
-				charge = state.charge + 1
+// floodGroup walks outward from seed across every transistor that is
+// currently conducting (base charge == 0, or no base at all) and returns
+// the indices of every wire reachable that way. visited is marked for
+// each index added, both to dedupe the group itself and so Step can skip
+// re-deriving a group it has already resolved this tick.
+func floodGroup(seed int, wires []*Wire, charges *chargeSet, visited bitmap, group []int) []int {
+	group = append(group, seed)
+	visited.set(seed)
+	for head := 0; head < len(group); head++ {
+		wire := wires[group[head]]
+		for _, transistor := range wire.transistors {
+			if nil != transistor.base && charges.get(transistor.base.index) > 0 {
+				continue
+			}
+			other := transistor.inputA
+			if other == wire {
+				other = transistor.inputB
 			}
-		} else {
-			source := s.tracePowerSource(state)
-			if source.charge > state.charge+1 {
-				charge = state.charge + 1
-			} else if source.charge <= state.charge && state.charge > 0 {
-				charge = state.charge - 1
+			if visited.get(other.index) {
+				continue
 			}
+			visited.set(other.index)
+			group = append(group, other.index)
 		}
-		newWireState[i] = WireState{charge, state.wire}
 	}
-	return &Simulation{s.circuit, newWireState}
+	return group
 }
 
-func (s *Simulation) tracePowerSource(origin WireState) WireState {
-	result := origin
-	for _, transistor := range origin.wire.transistors {
-		if nil != transistor.base && s.states[transistor.base.index].charge > 0 {
+func (s *Simulation) Step() *Simulation {
+	newCharges := s.charges.clone()
+
+	visited := newBitmap(len(s.circuit.wires))
+	dirty := newBitmap(len(s.circuit.wires))
+	recalcListOut := make([]int, 0, len(s.recalcListIn))
+	markDirty := func(index int) {
+		if dirty.get(index) {
+			return
+		}
+		dirty.set(index)
+		recalcListOut = append(recalcListOut, index)
+	}
+
+	mix := s.mix
+	maxCharge := s.model.MaxCharge()
+	var group []int
+	for _, seed := range s.recalcListIn {
+		if visited.get(seed) {
 			continue
 		}
-		if origin.wire == transistor.inputA {
-			inputBState := s.states[transistor.inputB.index]
-			if inputBState.charge == MaxCharge {
-				return inputBState
-			}
-			if inputBState.charge > result.charge {
-				result = inputBState
+		group = floodGroup(seed, s.circuit.wires, s.charges, visited, group[:0])
+		region := resolveGroupState(group, s.circuit.wires, s.charges, maxCharge)
+
+		for _, index := range group {
+			wire := s.circuit.wires[index]
+			old := s.charges.get(index)
+			local := resolveLocalState(wire, s.charges, maxCharge)
+			charge := s.model.NextCharge(wire, old, local, region)
+			if charge == old {
 				continue
 			}
-		} else if origin.wire == transistor.inputB {
-			inputAState := s.states[transistor.inputA.index]
-			if inputAState.charge == MaxCharge {
-				return inputAState
-			}
-			if inputAState.charge > result.charge {
-				result = inputAState
-				continue
+			newCharges.set(index, charge)
+			oldMix, newMix := mixCharge(index, old), mixCharge(index, charge)
+			mix[0] ^= oldMix[0] ^ newMix[0]
+			mix[1] ^= oldMix[1] ^ newMix[1]
+			markDirty(index)
+			for _, transistor := range wire.gatedBy {
+				markDirty(transistor.inputA.index)
+				markDirty(transistor.inputB.index)
 			}
 		}
 	}
-	return result
+
+	return &Simulation{s.circuit, newCharges, s.model, recalcListOut, mix}
 }
 
 func (s *Simulation) DiffDraw(previousSimulation *Simulation, img *image.Paletted) {
-	for i, state := range s.states {
-		if previousSimulation.states[i].charge == state.charge {
+	for _, wire := range s.circuit.wires {
+		charge := s.charges.get(wire.index)
+		if previousSimulation.charges.get(wire.index) == charge {
 			continue
 		}
-		state.wire.draw(img, state.charge+1)
+		wire.draw(img, charge+1)
 	}
 }
 
 func (s *Simulation) Draw(img *image.Paletted) {
-	for _, state := range s.states {
-		state.wire.draw(img, state.charge+1)
+	for _, wire := range s.circuit.wires {
+		wire.draw(img, s.charges.get(wire.index)+1)
 	}
 }
 
@@ -256,26 +331,38 @@ func (s *Simulation) FindLooping() (*Simulation, int) {
 	}
 }
 
+// mixCharge returns a pair of pseudo-random, independently-mixed values
+// for a given (wire index, charge) pair so Step can fold them into the
+// two lanes of the running mix with a handful of XORs per change instead
+// of re-hashing every wire every frame.
+func mixCharge(index int, charge uint8) [2]uint64 {
+	a := uint64(index)*0x9e3779b97f4a7c15 + uint64(charge)*0xc2b2ae3d27d4eb4f
+	a ^= a >> 33
+	a *= 0xff51afd7ed558ccd
+	a ^= a >> 33
+
+	b := uint64(index)*0xbf58476d1ce4e5b9 + uint64(charge)*0x94d049bb133111eb
+	b ^= b >> 31
+	b *= 0x2545f4914f6cdd1d
+	b ^= b >> 29
+
+	return [2]uint64{a, b}
+}
+
+// Hash returns a fingerprint of every wire's current charge, derived from
+// mix rather than re-walking the whole circuit. FindLooping compares
+// hashes across a growing number of frames, which makes collisions an
+// ordinary birthday-bound problem rather than an adversarial one: with
+// mix carrying 128 bits of rolling state across two independently-mixed
+// lanes, an accidental collision needs on the order of 2^64 frames to
+// become likely, far past any run this package is used for. Widen mix
+// with another lane if that ever stops being true.
 func (s *Simulation) Hash() []byte {
-	hash := sha1.New()
-
-	for index, state := range s.states {
-		buf := new(bytes.Buffer)
-
-		err := binary.Write(buf, binary.LittleEndian, uint32(index))
-		if err != nil {
-			log.Fatal(err)
-		}
-		err = binary.Write(buf, binary.LittleEndian, state.charge)
-		if err != nil {
-			log.Fatal(err)
-		}
-		_, err = hash.Write(buf.Bytes())
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	return hash.Sum(nil)
+	var buf [16]byte
+	binary.LittleEndian.PutUint64(buf[0:8], s.mix[0])
+	binary.LittleEndian.PutUint64(buf[8:16], s.mix[1])
+	hash := sha1.Sum(buf[:])
+	return hash[:]
 }
 
 type Transistor struct {
@@ -310,6 +397,9 @@ func newTransistor(position image.Point, base, inputA, inputB *Wire) *Transistor
 	}
 	inputA.transistors = append(inputA.transistors, transistor)
 	inputB.transistors = append(inputB.transistors, transistor)
+	if nil != base {
+		base.gatedBy = append(base.gatedBy, transistor)
+	}
 	return transistor
 }
 
@@ -318,7 +408,14 @@ type Wire struct {
 	pixels        []image.Point
 	bounds        image.Rectangle
 	transistors   []*Transistor
+	// gatedBy holds the transistors whose base is this wire, so Step can
+	// find the inputA/inputB pairs a change in this wire's charge just
+	// started or stopped conducting for, without scanning transistors for
+	// a base match. newTransistor populates it; Step's markDirty loop is
+	// its only reader (floodGroup walks transistors instead).
+	gatedBy       []*Transistor
 	isPowerSource bool
+	attributes    Attributes
 }
 
 func (w *Wire) Pixels() []image.Point {
@@ -337,12 +434,17 @@ func (w *Wire) IsPowerSource() bool {
 	return w.isPowerSource
 }
 
+func (w *Wire) Attributes() Attributes {
+	return w.attributes
+}
+
 func newWire() *Wire {
 	return &Wire{
 		index:         -1,
 		pixels:        make([]image.Point, 0),
 		bounds:        image.Rectangle{image.Pt(0, 0), image.Pt(0, 0)},
 		transistors:   make([]*Transistor, 0),
+		gatedBy:       make([]*Transistor, 0),
 		isPowerSource: false,
 	}
 }
@@ -0,0 +1,96 @@
+package simulation
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustLoadNetlist(t *testing.T, text string) *Simulation {
+	t.Helper()
+	sim, err := LoadNetlist(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("LoadNetlist: %v", err)
+	}
+	return sim
+}
+
+// TestStepRipplesThroughSeriesChain guards against Step resolving every
+// wire behind a chain of conducting transistors from one whole-group
+// state: charge must ripple hop by hop, the way a chain of series pass
+// transistors actually propagates, rather than every wire on the chain
+// jumping to the same value in lockstep.
+func TestStepRipplesThroughSeriesChain(t *testing.T) {
+	sim := mustLoadNetlist(t, `wired-logic-netlist v2
+wires 4
+0 1 6 0 0 0 0
+1 0 0 0 0 0 0
+2 0 0 0 0 0 0
+3 0 0 0 0 0 0
+transistors 3
+0 0 -1 0 1
+0 0 -1 1 2
+0 0 -1 2 3
+`)
+
+	want := [][3]uint8{
+		{1, 0, 0},
+		{2, 0, 0},
+		{3, 1, 0},
+		{4, 2, 0},
+		{5, 3, 1},
+	}
+	wires := sim.Circuit().Wires()
+	for i, w := range want {
+		sim = sim.Step()
+		got := [3]uint8{sim.State(wires[1]).Charge(), sim.State(wires[2]).Charge(), sim.State(wires[3]).Charge()}
+		if got != w {
+			t.Fatalf("step %d: got W1=%d W2=%d W3=%d, want W1=%d W2=%d W3=%d",
+				i+1, got[0], got[1], got[2], w[0], w[1], w[2])
+		}
+	}
+}
+
+// TestStepWakesFollowerWhenGateOpens guards against the conduction-change
+// wakeup going dead: a wire isolated behind a closed gate must resume
+// ramping as soon as its gate decays enough to conduct, instead of
+// freezing forever once both sides of the transistor have gone quiet.
+func TestStepWakesFollowerWhenGateOpens(t *testing.T) {
+	sim := mustLoadNetlist(t, `wired-logic-netlist v2
+wires 3
+0 0 6 0 0 0 0
+1 1 0 0 0 0 0
+2 0 0 0 0 0 0
+transistors 1
+0 0 0 1 2
+`)
+
+	follower := sim.Circuit().Wires()[2]
+	for step := 1; step <= 6; step++ {
+		sim = sim.Step()
+		if charge := sim.State(follower).Charge(); charge != 0 {
+			t.Fatalf("step %d: follower charge = %d while gate still closed, want 0", step, charge)
+		}
+	}
+
+	sim = sim.Step()
+	if charge := sim.State(follower).Charge(); charge != 1 {
+		t.Fatalf("step 7: follower charge = %d once gate opened, want 1", charge)
+	}
+}
+
+// TestFindLoopingDetectsFixedPoint exercises FindLooping against a
+// circuit with a real, if trivial, period: an isolated wire decays once
+// per Step until it bottoms out at zero, after which every further Step
+// repeats the same state.
+func TestFindLoopingDetectsFixedPoint(t *testing.T) {
+	sim := mustLoadNetlist(t, `wired-logic-netlist v2
+wires 1
+0 0 3 0 0 0 0
+transistors 0
+`)
+
+	_, period := sim.FindLooping()
+	if period != 1 {
+		t.Fatalf("FindLooping period = %d, want 1", period)
+	}
+}